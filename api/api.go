@@ -17,7 +17,6 @@
 package api
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 )
@@ -28,154 +27,116 @@ type connectChecker interface {
 }
 
 // healthApi encapsulates a system status object and
-// provides an HTTP/JSON handler for reporting system health.
+// provides an HTTP/JSON handler for reporting system health, globally,
+// per stream (/health/{stream}) and as a /streams listing.
+// The handler itself is generated by grpc-gateway from restreamer.proto,
+// so the JSON shape is defined once, in the .proto file, instead of being
+// duplicated here and in any external client.
 type healthApi struct {
-	stats Statistics
+	gateway http.Handler
 }
 
-// NewHealthApi creates a new health API object,
-// serving data from a system Statistics object.
-func NewHealthApi(stats Statistics) http.Handler {
+// NewHealthApi creates a new health API object, serving data from a system
+// Statistics object. clients is consulted for the /streams listing and to
+// resolve stream names for /health/{stream}; it may be nil if only the
+// global /health endpoint is needed.
+func NewHealthApi(stats Statistics, clients map[string]connectChecker) http.Handler {
+	gateway, err := newGateway(stats, clients)
+	if err != nil {
+		log.Fatalf("api: failed to build health gateway: %v", err)
+	}
 	return &healthApi{
-		stats: stats,
+		gateway: gateway,
 	}
 }
 
 // ServeHTTP is the http handler method.
 // It sends back information about system health.
 func (api *healthApi) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	global := api.stats.GetGlobalStatistics()
-	var stats struct {
-		Status    string `json:"status"`
-		Viewer    int    `json:"viewer"`
-		Limit     int    `json:"limit"`
-		Max       int    `json:"max"`
-		Bandwidth int    `json:"bandwidth"`
-	}
-	// report for both hard and soft, respecting disabled limits
-	if global.MaxConnections != 0 && global.Connections >= global.MaxConnections {
-		stats.Status = "full"
-	} else if global.FullConnections != 0 && global.Connections >= global.FullConnections {
-		stats.Status = "full"
-	} else {
-		stats.Status = "ok"
-	}
-	stats.Viewer = int(global.Connections)
-	stats.Limit = int(global.FullConnections)
-	stats.Max = int(global.MaxConnections)
-	stats.Bandwidth = int(global.BytesPerSecondSent * 8 / 1024) // kbit/s
-
-	writer.Header().Add("Content-Type", "application/json")
-	response, err := json.Marshal(&stats)
-	if err == nil {
-		writer.WriteHeader(http.StatusOK)
-		writer.Write(response)
-	} else {
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write([]byte(http.StatusText(http.StatusInternalServerError)))
-		log.Print(err)
-	}
+	api.gateway.ServeHTTP(writer, request)
 }
 
 // statisticsApi encapsulates a system status object and
-// provides an HTTP/JSON handler for reporting total system statistics.
+// provides an HTTP/JSON handler for reporting total system statistics,
+// globally and per stream (/statistics/{stream}).
+// The handler itself is generated by grpc-gateway from restreamer.proto,
+// so the JSON shape is defined once, in the .proto file, instead of being
+// duplicated here and in any external client.
 type statisticsApi struct {
-	stats Statistics
+	gateway http.Handler
 }
 
-// NewStatisticsApi creates a new statistics API object,
-// serving data from a system Statistics object.
-func NewStatisticsApi(stats Statistics) http.Handler {
+// NewStatisticsApi creates a new statistics API object, serving data from a
+// system Statistics object. clients is consulted to resolve stream names
+// for /statistics/{stream}; it may be nil if only the global /statistics
+// endpoint is needed.
+func NewStatisticsApi(stats Statistics, clients map[string]connectChecker) http.Handler {
+	gateway, err := newGateway(stats, clients)
+	if err != nil {
+		log.Fatalf("api: failed to build statistics gateway: %v", err)
+	}
 	return &statisticsApi{
-		stats: stats,
+		gateway: gateway,
 	}
 }
 
 // ServeHTTP is the http handler method.
 // It sends back information about system health.
 func (api *statisticsApi) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	global := api.stats.GetGlobalStatistics()
-	var stats struct {
-		Status                   string `json:"status"`
-		Connections              int    `json:"connections"`
-		MaxConnections           int    `json:"max_connections"`
-		FullConnections          int    `json:"full_connections"`
-		TotalPacketsReceived     uint64 `json:"total_packets_received"`
-		TotalPacketsSent         uint64 `json:"total_packets_sent"`
-		TotalPacketsDropped      uint64 `json:"total_packets_dropped"`
-		TotalBytesReceived       uint64 `json:"total_bytes_received"`
-		TotalBytesSent           uint64 `json:"total_bytes_sent"`
-		TotalBytesDropped        uint64 `json:"total_bytes_dropped"`
-		TotalStreamTime          int64  `json:"total_stream_time_ns"`
-		PacketsPerSecondReceived uint64 `json:"packets_per_second_received"`
-		PacketsPerSecondSent     uint64 `json:"packets_per_second_sent"`
-		PacketsPerSecondDropped  uint64 `json:"packets_per_second_dropped"`
-		BytesPerSecondReceived   uint64 `json:"bytes_per_second_received"`
-		BytesPerSecondSent       uint64 `json:"bytes_per_second_sent"`
-		BytesPerSecondDropped    uint64 `json:"bytes_per_second_dropped"`
-	}
-	// report for both hard and soft, respecting disabled limits
-	if global.MaxConnections != 0 && global.Connections >= global.MaxConnections {
-		stats.Status = "overload"
-	} else if global.FullConnections != 0 && global.Connections >= global.FullConnections {
-		stats.Status = "full"
-	} else {
-		stats.Status = "ok"
-	}
-	stats.Connections = int(global.Connections)
-	stats.MaxConnections = int(global.MaxConnections)
-	stats.FullConnections = int(global.FullConnections)
-	stats.TotalPacketsReceived = global.TotalPacketsReceived
-	stats.TotalPacketsSent = global.TotalPacketsSent
-	stats.TotalPacketsDropped = global.TotalPacketsDropped
-	stats.TotalBytesReceived = global.TotalBytesReceived
-	stats.TotalBytesSent = global.TotalBytesSent
-	stats.TotalBytesDropped = global.TotalBytesDropped
-	stats.TotalStreamTime = global.TotalStreamTime
-	stats.PacketsPerSecondReceived = global.PacketsPerSecondReceived
-	stats.PacketsPerSecondSent = global.PacketsPerSecondSent
-	stats.PacketsPerSecondDropped = global.PacketsPerSecondDropped
-	stats.BytesPerSecondReceived = global.BytesPerSecondReceived
-	stats.BytesPerSecondSent = global.BytesPerSecondSent
-	stats.BytesPerSecondDropped = global.BytesPerSecondDropped
-
-	writer.Header().Add("Content-Type", "application/json")
-	response, err := json.Marshal(&stats)
-	if err == nil {
-		writer.WriteHeader(http.StatusOK)
-		writer.Write(response)
-	} else {
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write([]byte("500 internal server error"))
-		log.Print(err)
-	}
+	api.gateway.ServeHTTP(writer, request)
 }
 
-// StreamStatApi provides an API for checking stream availability.
-// The HTTP handler returns status code 200 if a stream is connected
-// and 404 if not.
+// streamStateKey is the map key a streamStateApi registers its single
+// connectChecker under, so it can address it through the same
+// GetStreamState RPC and /streamstate/{stream} route as everything else.
+const streamStateKey = "stream"
+
+// noStatistics is a Statistics that reports nothing. streamStateApi's
+// gateway never serves /health or /statistics, only /streamstate/{stream},
+// so it has no real Statistics object to hand newGateway.
+type noStatistics struct{}
+
+func (noStatistics) GetGlobalStatistics() Stats {
+	return Stats{}
+}
+
+func (noStatistics) GetStreamStatistics(name string) (Stats, bool) {
+	return Stats{}, false
+}
+
+// streamStateApi provides an API for checking stream availability.
+// The handler itself is generated by grpc-gateway from restreamer.proto,
+// via the same GetStreamState RPC and /streamstate/{stream} route used for
+// any other stream, so there is a single source of truth for stream state
+// instead of this handler's own plain-text format diverging from it.
+// GetStreamState reports NotFound for both an unknown stream and a known
+// but disconnected one, so the gateway still answers with HTTP 404 when
+// the stream isn't connected, matching the original plain-text contract.
+// Since GetStreamState is unary, this uses the in-process gateway and
+// never needs its own gRPC listener.
 type streamStateApi struct {
-	client connectChecker
+	gateway http.Handler
 }
 
 // NewStreamStateApi creates a new stream status API object,
 // serving the "connected" status of a stream connection.
 func NewStreamStateApi(client connectChecker) http.Handler {
+	gateway, err := newUnaryGateway(noStatistics{}, map[string]connectChecker{streamStateKey: client})
+	if err != nil {
+		log.Fatalf("api: failed to build stream state gateway: %v", err)
+	}
 	return &streamStateApi{
-		client: client,
+		gateway: gateway,
 	}
 }
 
-// ServeHTTP is the http handler method.
-// It sends back "200 ok" if the stream is connected and "404 not found" if not,
-// along with the corresponding HTTP status code.
-func (stat *streamStateApi) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	writer.Header().Add("Content-Type", "text/plain")
-	if stat.client.Connected() {
-		writer.WriteHeader(http.StatusOK)
-		writer.Write([]byte("200 ok"))
-	} else {
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write([]byte("404 not found"))
-	}
+// ServeHTTP is the http handler method. It sends back HTTP 200 with the
+// JSON body GetStreamState replies with, {"connected": true}, if the
+// stream is connected, and HTTP 404 otherwise, regardless of the path it's
+// mounted under, by addressing its one connectChecker through
+// streamStateKey.
+func (api *streamStateApi) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	proxied := request.Clone(request.Context())
+	proxied.URL.Path = "/streamstate/" + streamStateKey
+	api.gateway.ServeHTTP(writer, proxied)
 }
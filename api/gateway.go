@@ -0,0 +1,107 @@
+/* Copyright (c) 2016-2017 Gregor Riepl
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	pb "github.com/dubrsl/restreamer/api/grpc"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// sharedStreamingGateway lazily starts the one gRPC server + loopback
+// listener + client conn this process needs to bridge the Watch* RPCs
+// through grpc-gateway, and hands every caller the same conn. NewHealthApi
+// and NewStatisticsApi are expected to be given the same node-wide
+// Statistics and clients map (mirroring NewGrpcServer, which is likewise
+// meant to be the one Api service for a node), so only the first caller's
+// arguments are actually used to build it.
+var sharedStreamingGateway struct {
+	once sync.Once
+	conn *grpc.ClientConn
+	err  error
+}
+
+func streamingConn(stats Statistics, clients map[string]connectChecker) (*grpc.ClientConn, error) {
+	sharedStreamingGateway.once.Do(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			sharedStreamingGateway.err = fmt.Errorf("api: listen for internal gateway grpc server: %w", err)
+			return
+		}
+
+		grpcServer := NewGrpcServer(stats, clients)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Printf("api: internal gateway grpc server stopped: %v", err)
+			}
+		}()
+
+		conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			sharedStreamingGateway.err = fmt.Errorf("api: dial internal gateway grpc server: %w", err)
+			grpcServer.Stop()
+			return
+		}
+		sharedStreamingGateway.conn = conn
+	})
+	return sharedStreamingGateway.conn, sharedStreamingGateway.err
+}
+
+// newGateway builds a grpc-gateway mux that forwards REST/JSON requests,
+// including the Watch* streams, to the process-wide gRPC server. It is the
+// single source of truth for the REST mapping that NewHealthApi and
+// NewStatisticsApi used to hand-roll as anonymous JSON structs.
+//
+// grpc-gateway's in-process handler (RegisterApiHandlerServer) doesn't
+// support server-streaming RPCs, so this goes through a real client conn
+// instead; see streamingConn for why that conn is shared rather than
+// dialed again per caller.
+func newGateway(stats Statistics, clients map[string]connectChecker) (*runtime.ServeMux, error) {
+	conn, err := streamingConn(stats, clients)
+	if err != nil {
+		return nil, err
+	}
+	mux := runtime.NewServeMux()
+	if err := pb.RegisterApiHandlerClient(context.Background(), mux, pb.NewApiClient(conn)); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+// newUnaryGateway builds a grpc-gateway mux for a caller that only needs
+// unary RPCs (no Watch* routes), such as streamStateApi. It registers the
+// grpcServer in process, with no extra listener, goroutine or conn, since
+// grpc-gateway's in-process handler works fine as long as streaming routes
+// are never dispatched through it.
+func newUnaryGateway(stats Statistics, clients map[string]connectChecker) (*runtime.ServeMux, error) {
+	server := &grpcServer{
+		stats:   stats,
+		clients: clients,
+	}
+	mux := runtime.NewServeMux()
+	if err := pb.RegisterApiHandlerServer(context.Background(), mux, server); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
@@ -0,0 +1,229 @@
+/* Copyright (c) 2016-2017 Gregor Riepl
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	pb "github.com/dubrsl/restreamer/api/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultWatchTick is the interval at which Watch* RPCs check the
+// underlying statistics for changes when the request sets no tick; a
+// snapshot is only pushed when it differs from the last one sent.
+const defaultWatchTick = 5 * time.Second
+
+// keepalive parameters for the gRPC listener, so subscribers that vanished
+// without closing their stream (half-open TCP connections) are dropped
+// promptly instead of leaking a goroutine and a ticker per client.
+var grpcKeepalive = keepalive.ServerParameters{
+	Time:    30 * time.Second,
+	Timeout: 10 * time.Second,
+}
+
+// grpcServer implements the Api gRPC service declared in
+// api/grpc/restreamer.proto, serving the same data as healthApi,
+// statisticsApi and streamStateApi.
+type grpcServer struct {
+	pb.UnimplementedApiServer
+	stats   Statistics
+	clients map[string]connectChecker
+}
+
+// NewGrpcServer creates a gRPC server that exposes health, statistics and
+// stream state, both as unary calls and as server-streaming subscriptions,
+// so dashboards can watch once instead of polling /health and /statistics.
+// It is meant to be served on its own listener, next to the HTTP mux
+// returned by NewHealthApi, NewStatisticsApi and NewStreamStateApi.
+func NewGrpcServer(stats Statistics, clients map[string]connectChecker) *grpc.Server {
+	server := grpc.NewServer(grpc.KeepaliveParams(grpcKeepalive))
+	pb.RegisterApiServer(server, &grpcServer{
+		stats:   stats,
+		clients: clients,
+	})
+	return server
+}
+
+// healthFromStats converts a statistics snapshot into a HealthReply. It's
+// shared by the global and per-stream RPCs, and by the Watch variant.
+func healthFromStats(data Stats) *pb.HealthReply {
+	reply := &pb.HealthReply{
+		Viewer:    int32(data.Connections),
+		Limit:     int32(data.FullConnections),
+		Max:       int32(data.MaxConnections),
+		Bandwidth: int32(data.BytesPerSecondSent * 8 / 1024), // kbit/s
+	}
+	// report for both hard and soft, respecting disabled limits
+	if data.MaxConnections != 0 && data.Connections >= data.MaxConnections {
+		reply.Status = "full"
+	} else if data.FullConnections != 0 && data.Connections >= data.FullConnections {
+		reply.Status = "full"
+	} else {
+		reply.Status = "ok"
+	}
+	return reply
+}
+
+// statisticsFromStats converts a statistics snapshot into a StatisticsReply.
+// It's shared by the global and per-stream RPCs, and by the Watch variant.
+func statisticsFromStats(data Stats) *pb.StatisticsReply {
+	reply := &pb.StatisticsReply{
+		Connections:              int32(data.Connections),
+		MaxConnections:           int32(data.MaxConnections),
+		FullConnections:          int32(data.FullConnections),
+		TotalPacketsReceived:     data.TotalPacketsReceived,
+		TotalPacketsSent:         data.TotalPacketsSent,
+		TotalPacketsDropped:      data.TotalPacketsDropped,
+		TotalBytesReceived:       data.TotalBytesReceived,
+		TotalBytesSent:           data.TotalBytesSent,
+		TotalBytesDropped:        data.TotalBytesDropped,
+		TotalStreamTimeNs:        data.TotalStreamTime,
+		PacketsPerSecondReceived: data.PacketsPerSecondReceived,
+		PacketsPerSecondSent:     data.PacketsPerSecondSent,
+		PacketsPerSecondDropped:  data.PacketsPerSecondDropped,
+		BytesPerSecondReceived:   data.BytesPerSecondReceived,
+		BytesPerSecondSent:       data.BytesPerSecondSent,
+		BytesPerSecondDropped:    data.BytesPerSecondDropped,
+	}
+	// report for both hard and soft, respecting disabled limits
+	if data.MaxConnections != 0 && data.Connections >= data.MaxConnections {
+		reply.Status = "overload"
+	} else if data.FullConnections != 0 && data.Connections >= data.FullConnections {
+		reply.Status = "full"
+	} else {
+		reply.Status = "ok"
+	}
+	return reply
+}
+
+// GetHealth implements the unary health RPC.
+func (server *grpcServer) GetHealth(ctx context.Context, request *pb.HealthRequest) (*pb.HealthReply, error) {
+	return healthFromStats(server.stats.GetGlobalStatistics()), nil
+}
+
+// GetStatistics implements the unary statistics RPC.
+func (server *grpcServer) GetStatistics(ctx context.Context, request *pb.StatisticsRequest) (*pb.StatisticsReply, error) {
+	return statisticsFromStats(server.stats.GetGlobalStatistics()), nil
+}
+
+// GetStreamState implements the unary stream state RPC. It reports
+// NotFound both for an unknown stream and for a known but disconnected
+// one, so the gateway answers with HTTP 404 in both cases, matching the
+// HTTP stream state endpoint's original "200 ok" / "404 not found"
+// contract that load balancers and liveness probes rely on.
+func (server *grpcServer) GetStreamState(ctx context.Context, request *pb.StreamStateRequest) (*pb.StreamStateReply, error) {
+	client, ok := server.clients[request.Stream]
+	if !ok || !client.Connected() {
+		return nil, status.Errorf(codes.NotFound, "stream %q not connected", request.Stream)
+	}
+	return &pb.StreamStateReply{Connected: true}, nil
+}
+
+// GetStreamHealth implements GetHealth scoped to a single stream.
+func (server *grpcServer) GetStreamHealth(ctx context.Context, request *pb.StreamRequest) (*pb.HealthReply, error) {
+	data, ok := server.stats.GetStreamStatistics(request.Stream)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown stream %q", request.Stream)
+	}
+	return healthFromStats(data), nil
+}
+
+// GetStreamStatistics implements GetStatistics scoped to a single stream.
+func (server *grpcServer) GetStreamStatistics(ctx context.Context, request *pb.StreamRequest) (*pb.StatisticsReply, error) {
+	data, ok := server.stats.GetStreamStatistics(request.Stream)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown stream %q", request.Stream)
+	}
+	return statisticsFromStats(data), nil
+}
+
+// ListStreams implements the stream listing RPC, reporting the connection
+// state of every stream this node knows about.
+func (server *grpcServer) ListStreams(ctx context.Context, request *pb.ListStreamsRequest) (*pb.ListStreamsReply, error) {
+	reply := &pb.ListStreamsReply{
+		Streams: make([]*pb.StreamInfo, 0, len(server.clients)),
+	}
+	for name, client := range server.clients {
+		reply.Streams = append(reply.Streams, &pb.StreamInfo{
+			Name:      name,
+			Connected: client.Connected(),
+		})
+	}
+	return reply, nil
+}
+
+// WatchHealth streams a HealthReply whenever global statistics change. The
+// tick interval only sets how often the snapshot is checked for changes,
+// not a minimum send rate: an unchanged snapshot is never resent.
+func (server *grpcServer) WatchHealth(request *pb.WatchRequest, stream pb.Api_WatchHealthServer) error {
+	return server.watch(stream.Context(), request.TickSeconds, func() proto.Message {
+		return healthFromStats(server.stats.GetGlobalStatistics())
+	}, func(reply proto.Message) error {
+		return stream.Send(reply.(*pb.HealthReply))
+	})
+}
+
+// WatchStatistics streams a StatisticsReply whenever global statistics
+// change. The tick interval only sets how often the snapshot is checked
+// for changes, not a minimum send rate: an unchanged snapshot is never
+// resent.
+func (server *grpcServer) WatchStatistics(request *pb.WatchRequest, stream pb.Api_WatchStatisticsServer) error {
+	return server.watch(stream.Context(), request.TickSeconds, func() proto.Message {
+		return statisticsFromStats(server.stats.GetGlobalStatistics())
+	}, func(reply proto.Message) error {
+		return stream.Send(reply.(*pb.StatisticsReply))
+	})
+}
+
+// watch polls snapshot on every tick and calls send whenever the marshalled
+// snapshot differs from the previously sent one, so subscribers only wake
+// up on an actual change instead of a fixed schedule.
+func (server *grpcServer) watch(ctx context.Context, tickSeconds int32, snapshot func() proto.Message, send func(proto.Message) error) error {
+	tick := defaultWatchTick
+	if tickSeconds > 0 {
+		tick = time.Duration(tickSeconds) * time.Second
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		reply := snapshot()
+		current, err := proto.Marshal(reply)
+		if err != nil {
+			return status.Errorf(codes.Internal, "marshal snapshot: %v", err)
+		}
+		if !bytes.Equal(current, last) {
+			if err := send(reply); err != nil {
+				return err
+			}
+			last = current
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,28 @@
+/* Copyright (c) 2016-2017 Gregor Riepl
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package grpc holds the generated protobuf/gRPC stubs for restreamer.proto,
+// including the grpc-gateway reverse-proxy stubs that turn the same service
+// definition into a REST/JSON handler.
+//
+// The stubs (restreamer.pb.go, restreamer_grpc.pb.go, restreamer.pb.gw.go)
+// are produced by protoc and are not checked into version control; run
+// `go generate` with protoc, protoc-gen-go, protoc-gen-go-grpc and
+// protoc-gen-grpc-gateway on the PATH to (re)create them before building
+// this package.
+package grpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative --grpc-gateway_out=. --grpc-gateway_opt=paths=source_relative restreamer.proto
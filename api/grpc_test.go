@@ -0,0 +1,104 @@
+/* Copyright (c) 2016-2017 Gregor Riepl
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "github.com/dubrsl/restreamer/api/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHealthFromStatsStatus(t *testing.T) {
+	cases := []struct {
+		name string
+		data Stats
+		want string
+	}{
+		{"under limits", Stats{Connections: 1, FullConnections: 10, MaxConnections: 20}, "ok"},
+		{"at soft limit", Stats{Connections: 10, FullConnections: 10, MaxConnections: 20}, "full"},
+		{"at hard limit", Stats{Connections: 20, FullConnections: 10, MaxConnections: 20}, "full"},
+		{"limits disabled", Stats{Connections: 1000}, "ok"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := healthFromStats(c.data).Status; got != c.want {
+				t.Errorf("healthFromStats(%+v).Status = %q, want %q", c.data, got, c.want)
+			}
+		})
+	}
+}
+
+func TestStatisticsFromStatsStatus(t *testing.T) {
+	cases := []struct {
+		name string
+		data Stats
+		want string
+	}{
+		{"under limits", Stats{Connections: 1, FullConnections: 10, MaxConnections: 20}, "ok"},
+		{"at soft limit", Stats{Connections: 10, FullConnections: 10, MaxConnections: 20}, "full"},
+		{"at hard limit", Stats{Connections: 20, FullConnections: 10, MaxConnections: 20}, "overload"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := statisticsFromStats(c.data).Status; got != c.want {
+				t.Errorf("statisticsFromStats(%+v).Status = %q, want %q", c.data, got, c.want)
+			}
+		})
+	}
+}
+
+// TestWatchOnlyResendsOnChange drives watch() through a scripted sequence of
+// snapshots, most of them repeats, and checks that send is only called when
+// the marshalled snapshot actually changes.
+func TestWatchOnlyResendsOnChange(t *testing.T) {
+	snapshots := []Stats{
+		{Connections: 1}, // initial, always sent
+		{Connections: 1}, // unchanged, must not resend
+		{Connections: 2}, // changed, must send
+	}
+	index := 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var server grpcServer
+	var sent []int32
+
+	err := server.watch(ctx, 1, func() proto.Message {
+		data := snapshots[index]
+		if index < len(snapshots)-1 {
+			index++
+		}
+		return healthFromStats(data)
+	}, func(msg proto.Message) error {
+		sent = append(sent, msg.(*pb.HealthReply).Viewer)
+		if len(sent) >= 2 {
+			cancel()
+		}
+		return nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("watch() error = %v, want context.Canceled", err)
+	}
+	if want := []int32{1, 2}; len(sent) != len(want) || sent[0] != want[0] || sent[1] != want[1] {
+		t.Fatalf("watch() sent %v, want %v (initial + one change, no resend of the repeat)", sent, want)
+	}
+}
@@ -0,0 +1,173 @@
+/* Copyright (c) 2016-2017 Gregor Riepl
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// metricsApi encapsulates a system status object and provides a
+// Prometheus/OpenMetrics text exposition handler, so operators can scrape
+// the same counters served as JSON by statisticsApi and healthApi with a
+// standard tool instead of a custom polling exporter.
+type metricsApi struct {
+	stats   Statistics
+	clients map[string]connectChecker
+}
+
+// NewMetricsApi creates a new metrics API object, serving data from a
+// system Statistics object in Prometheus text exposition format. clients
+// is used to enumerate the known streams for the per-stream series; it may
+// be nil if only the process-level gauges are needed.
+func NewMetricsApi(stats Statistics, clients map[string]connectChecker) http.Handler {
+	return &metricsApi{
+		stats:   stats,
+		clients: clients,
+	}
+}
+
+// healthStatus reproduces the status computed by healthApi: "full" once
+// either the soft or the hard connection limit is reached.
+func healthStatus(data Stats) string {
+	if data.MaxConnections != 0 && data.Connections >= data.MaxConnections {
+		return "full"
+	}
+	if data.FullConnections != 0 && data.Connections >= data.FullConnections {
+		return "full"
+	}
+	return "ok"
+}
+
+// streamSample pairs a per-stream statistics snapshot with the labels it
+// should be exposed under. An empty stream means the global aggregate.
+type streamSample struct {
+	stream string
+	status string
+	data   Stats
+}
+
+// ServeHTTP is the http handler method.
+// It sends back the same counters as statisticsApi and healthApi, in
+// Prometheus text exposition format. Counters are exposed as monotonic
+// "_total" series, one per stream; rates are left for the scraper to
+// compute, and so is summing them into a process-wide total, since a
+// global and a per-stream sample of the same "_total" series would be
+// double-counted by sum().
+func (api *metricsApi) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	global := api.stats.GetGlobalStatistics()
+
+	names := make([]string, 0, len(api.clients))
+	for name := range api.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	streams := make([]streamSample, 0, len(names))
+	for _, name := range names {
+		data, ok := api.stats.GetStreamStatistics(name)
+		if !ok {
+			continue
+		}
+		streams = append(streams, streamSample{stream: name, status: healthStatus(data), data: data})
+	}
+
+	writer.Header().Add("Content-Type", "text/plain; version=0.0.4")
+	writer.WriteHeader(http.StatusOK)
+	writeMetrics(writer, global, streams)
+}
+
+// writeMetrics renders the gauges and counters described by ServeHTTP to w,
+// without touching the ResponseWriter-specific header/status machinery, so
+// it can be exercised directly in tests against a plain io.Writer.
+func writeMetrics(w io.Writer, global Stats, streams []streamSample) {
+	writeGauge(w, "restreamer_viewer", "Current number of connected viewers.", fmt.Sprintf("%d", global.Connections))
+	writeGauge(w, "restreamer_limit", "Soft connection limit, 0 if disabled.", fmt.Sprintf("%d", global.FullConnections))
+	writeGauge(w, "restreamer_max", "Hard connection limit, 0 if disabled.", fmt.Sprintf("%d", global.MaxConnections))
+	writeGauge(w, "restreamer_bandwidth_kbps", "Current outbound bandwidth in kbit/s.", fmt.Sprintf("%d", global.BytesPerSecondSent*8/1024))
+
+	// status is an enum, not a monotonic value, so it's exposed as a gauge
+	// set to 1 for the stream's current status rather than as a label on
+	// the "_total" counters below: a label that changes value resets
+	// rate()/increase() on whatever counter it's attached to. The HELP and
+	// TYPE lines are written once for all of its samples.
+	fmt.Fprintf(w, "# HELP %s Current status (1 for the active state) of a stream, or of the node as a whole if stream is unset.\n", restreamerStreamStatus)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", restreamerStreamStatus)
+	writeStatusGauge(w, "", healthStatus(global))
+	for _, sample := range streams {
+		writeStatusGauge(w, sample.stream, sample.status)
+	}
+
+	writeCounter(w, "restreamer_packets_received_total", "Total number of packets received, per stream.", streams, func(data Stats) uint64 { return data.TotalPacketsReceived })
+	writeCounter(w, "restreamer_packets_sent_total", "Total number of packets sent, per stream.", streams, func(data Stats) uint64 { return data.TotalPacketsSent })
+	writeCounter(w, "restreamer_packets_dropped_total", "Total number of packets dropped, per stream.", streams, func(data Stats) uint64 { return data.TotalPacketsDropped })
+	writeCounter(w, "restreamer_bytes_received_total", "Total number of bytes received, per stream.", streams, func(data Stats) uint64 { return data.TotalBytesReceived })
+	writeCounter(w, "restreamer_bytes_sent_total", "Total number of bytes sent, per stream.", streams, func(data Stats) uint64 { return data.TotalBytesSent })
+	writeCounter(w, "restreamer_bytes_dropped_total", "Total number of bytes dropped, per stream.", streams, func(data Stats) uint64 { return data.TotalBytesDropped })
+	writeCounter(w, "restreamer_stream_time_ns_total", "Total stream time in nanoseconds, per stream.", streams, func(data Stats) uint64 { return uint64(data.TotalStreamTime) })
+}
+
+// writeGauge emits a single-sample gauge, with no stream/status labels: it
+// describes the process as a whole, mirroring healthApi's global fields.
+func writeGauge(w io.Writer, name string, help string, value string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %s\n", name, value)
+}
+
+// restreamerStreamStatus is the metric name shared by the global and
+// per-stream status gauges.
+const restreamerStreamStatus = "restreamer_stream_status"
+
+// writeStatusGauge emits restreamer_stream_status{...} 1 for the given
+// stream's current status, with an empty stream omitting the stream
+// label so the global aggregate reads as the metric's base series. It
+// writes the sample only; the caller writes the HELP/TYPE header once.
+func writeStatusGauge(w io.Writer, stream string, status string) {
+	labels := fmt.Sprintf(`status="%s"`, escapeLabelValue(status))
+	if stream != "" {
+		labels = fmt.Sprintf(`stream="%s",%s`, escapeLabelValue(stream), labels)
+	}
+	fmt.Fprintf(w, "%s{%s} 1\n", restreamerStreamStatus, labels)
+}
+
+// writeCounter emits one HELP/TYPE header followed by one sample per
+// stream, each tagged with a stream label. It deliberately has no global,
+// unlabelled sample: summing the per-stream series gives the process
+// total, and a redundant global sample of the same "_total" metric would
+// be double-counted by sum().
+func writeCounter(w io.Writer, name string, help string, streams []streamSample, value func(Stats) uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, sample := range streams {
+		labels := fmt.Sprintf(`stream="%s"`, escapeLabelValue(sample.stream))
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels, value(sample.data))
+	}
+}
+
+// escapeLabelValue escapes a Prometheus text-format label value per the
+// exposition format spec: backslash, double quote and newline all need a
+// backslash escape, since stream names are arbitrary and may contain them.
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return value
+}
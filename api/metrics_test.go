@@ -0,0 +1,120 @@
+/* Copyright (c) 2016-2017 Gregor Riepl
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEscapeLabelValue(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "camera1", "camera1"},
+		{"backslash", `back\slash`, `back\\slash`},
+		{"quote", `quo"te`, `quo\"te`},
+		{"newline", "line1\nline2", `line1\nline2`},
+		{"all three", "a\\b\"c\nd", `a\\b\"c\nd`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := escapeLabelValue(c.value); got != c.want {
+				t.Errorf("escapeLabelValue(%q) = %q, want %q", c.value, got, c.want)
+			}
+		})
+	}
+}
+
+func TestHealthStatus(t *testing.T) {
+	cases := []struct {
+		name string
+		data Stats
+		want string
+	}{
+		{"under limits", Stats{Connections: 1, FullConnections: 10, MaxConnections: 20}, "ok"},
+		{"at soft limit", Stats{Connections: 10, FullConnections: 10, MaxConnections: 20}, "full"},
+		{"at hard limit", Stats{Connections: 20, FullConnections: 10, MaxConnections: 20}, "full"},
+		{"limits disabled", Stats{Connections: 1000}, "ok"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := healthStatus(c.data); got != c.want {
+				t.Errorf("healthStatus(%+v) = %q, want %q", c.data, got, c.want)
+			}
+		})
+	}
+}
+
+func TestWriteMetricsPerStreamCountersHaveNoGlobalSample(t *testing.T) {
+	global := Stats{Connections: 5, TotalPacketsReceived: 100}
+	streams := []streamSample{
+		{stream: "cam1", status: "ok", data: Stats{Connections: 2, TotalPacketsReceived: 40}},
+		{stream: "cam2", status: "full", data: Stats{Connections: 3, TotalPacketsReceived: 60}},
+	}
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, global, streams)
+	out := buf.String()
+
+	if strings.Contains(out, `restreamer_packets_received_total{} `) {
+		t.Errorf("counter has an unlabelled (global) sample, which double-counts alongside the per-stream ones:\n%s", out)
+	}
+	if !strings.Contains(out, `restreamer_packets_received_total{stream="cam1"} 40`) {
+		t.Errorf("missing per-stream sample for cam1:\n%s", out)
+	}
+	if !strings.Contains(out, `restreamer_packets_received_total{stream="cam2"} 60`) {
+		t.Errorf("missing per-stream sample for cam2:\n%s", out)
+	}
+}
+
+func TestWriteMetricsStatusIsAGaugeNotACounterLabel(t *testing.T) {
+	global := Stats{Connections: 1}
+	streams := []streamSample{
+		{stream: "cam1", status: "full", data: Stats{Connections: 1}},
+	}
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, global, streams)
+	out := buf.String()
+
+	if strings.Contains(out, `status=`) == false {
+		t.Fatalf("expected a status label somewhere in the output:\n%s", out)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, "_total{") && strings.Contains(line, "status=") {
+			t.Errorf("a _total counter carries a status label, which would reset rate()/increase() on a status change: %q", line)
+		}
+	}
+	if !strings.Contains(out, `restreamer_stream_status{stream="cam1",status="full"} 1`) {
+		t.Errorf("missing per-stream status gauge sample:\n%s", out)
+	}
+}
+
+func TestEscapeLabelValueKeepsExpositionWellFormed(t *testing.T) {
+	var buf bytes.Buffer
+	streams := []streamSample{
+		{stream: `cam"1`, status: "ok", data: Stats{TotalPacketsReceived: 1}},
+	}
+	writeMetrics(&buf, Stats{}, streams)
+	if !strings.Contains(buf.String(), `stream="cam\"1"`) {
+		t.Errorf("stream name with a quote wasn't escaped in the exposition output:\n%s", buf.String())
+	}
+}